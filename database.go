@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// CreateDatabase creates the database named in connURL by connecting to the
+// "postgres" maintenance database with the same credentials and issuing
+// CREATE DATABASE. The query parameters x-template, x-encoding and x-owner
+// set the corresponding CREATE DATABASE clauses when present.
+func (driver *Driver) CreateDatabase(connURL string) error {
+	u, name, err := parseDatabaseURL(connURL)
+	if err != nil {
+		return err
+	}
+
+	db, err := maintenanceDB(u)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	q := u.Query()
+	stmt := "CREATE DATABASE " + pq.QuoteIdentifier(name)
+	if tmpl := q.Get("x-template"); tmpl != "" {
+		stmt += " TEMPLATE " + pq.QuoteIdentifier(tmpl)
+	}
+	if enc := q.Get("x-encoding"); enc != "" {
+		stmt += " ENCODING " + pq.QuoteLiteral(enc)
+	}
+	if owner := q.Get("x-owner"); owner != "" {
+		stmt += " OWNER " + pq.QuoteIdentifier(owner)
+	}
+
+	_, err = db.Exec(stmt)
+	return err
+}
+
+// DropDatabase drops the database named in connURL by connecting to the
+// "postgres" maintenance database with the same credentials. When the
+// query parameter x-force-drop=true is set, existing backends connected to
+// the database are terminated first via pg_terminate_backend so the drop
+// does not fail with "database is being accessed by other users".
+func (driver *Driver) DropDatabase(connURL string) error {
+	u, name, err := parseDatabaseURL(connURL)
+	if err != nil {
+		return err
+	}
+
+	db, err := maintenanceDB(u)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if u.Query().Get("x-force-drop") == "true" {
+		_, err := db.Exec(`SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid()`, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec("DROP DATABASE IF EXISTS " + pq.QuoteIdentifier(name))
+	return err
+}
+
+// parseDatabaseURL parses connURL and extracts the target database name
+// from its path.
+func parseDatabaseURL(connURL string) (*url.URL, string, error) {
+	u, err := url.Parse(connURL)
+	if err != nil {
+		return nil, "", err
+	}
+	name := strings.TrimPrefix(u.Path, "/")
+	if name == "" {
+		return nil, "", fmt.Errorf("postgres: no database name in %q", connURL)
+	}
+	return u, name, nil
+}
+
+// maintenanceDB opens a connection to the "postgres" maintenance database
+// using the same credentials as u.
+func maintenanceDB(u *url.URL) (*sql.DB, error) {
+	maintURL := *u
+	maintURL.Path = "/postgres"
+	stripDriverParams(&maintURL)
+	return sql.Open("postgres", maintURL.String())
+}
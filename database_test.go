@@ -0,0 +1,54 @@
+package postgres
+
+import "testing"
+
+func TestParseDatabaseURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		connURL  string
+		wantName string
+		wantErr  bool
+	}{
+		{
+			name:     "simple path",
+			connURL:  "postgres://localhost/mydb",
+			wantName: "mydb",
+		},
+		{
+			name:     "path with query params",
+			connURL:  "postgres://localhost/mydb?sslmode=disable&x-template=template0",
+			wantName: "mydb",
+		},
+		{
+			name:    "no database name",
+			connURL: "postgres://localhost/",
+			wantErr: true,
+		},
+		{
+			name:    "invalid URL",
+			connURL: "postgres://loc alhost/mydb",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, name, err := parseDatabaseURL(tt.connURL)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseDatabaseURL(%q) = nil error, want error", tt.connURL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDatabaseURL(%q) = %v, want no error", tt.connURL, err)
+			}
+			if name != tt.wantName {
+				t.Errorf("parseDatabaseURL(%q) name = %q, want %q", tt.connURL, name, tt.wantName)
+			}
+			if u == nil {
+				t.Errorf("parseDatabaseURL(%q) returned nil *url.URL", tt.connURL)
+			}
+		})
+	}
+}
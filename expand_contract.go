@@ -0,0 +1,298 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/db-journey/migrate/file"
+	"github.com/lib/pq"
+)
+
+// pendingTable is the unqualified name of the table that tracks migrations
+// which have run their expand phase but not yet been completed with a
+// contract phase, so old and new application versions can run concurrently
+// against the same schema. It lives alongside the migrations version table,
+// in driver.schema, so that deployments using different x-migrations-schema
+// values don't share pending state.
+const pendingTable = "schema_migrations_pending"
+
+// qualifiedPendingTable returns the pending-migrations table as a quoted
+// "schema"."table" identifier suitable for inlining into SQL.
+func (driver *Driver) qualifiedPendingTable() string {
+	schema := driver.schema
+	if schema == "" {
+		schema = defaultMigrationsSchema
+	}
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(pendingTable)
+}
+
+// operationsHeader introduces an expand/contract operations block at the
+// top of a migration file. When present, Migrate runs the two-phase
+// expand/contract flow instead of executing the file content as raw SQL.
+const operationsHeader = "-- operations:"
+
+// Operation describes a single high-level schema change understood by the
+// expand/contract migration mode.
+type Operation struct {
+	Type      string `json:"type"`
+	Table     string `json:"table"`
+	Column    string `json:"column,omitempty"`
+	NewColumn string `json:"new_column,omitempty"`
+	NewType   string `json:"new_type,omitempty"`
+	Default   string `json:"default,omitempty"`
+	NotNull   bool   `json:"not_null,omitempty"`
+}
+
+// parseOperations extracts the JSON operations array following an
+// "-- operations:" header in the migration file's leading comment block.
+// It returns a nil slice, with no error, when the header is absent so
+// callers fall back to the plain raw-SQL path.
+func parseOperations(content []byte) ([]Operation, error) {
+	lines := strings.Split(string(content), "\n")
+
+	headerIdx := -1
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if trimmed == strings.TrimSpace(operationsHeader) {
+			headerIdx = i
+			break
+		}
+		if !strings.HasPrefix(trimmed, "--") {
+			break
+		}
+	}
+	if headerIdx == -1 {
+		return nil, nil
+	}
+
+	var buf strings.Builder
+	for _, l := range lines[headerIdx+1:] {
+		trimmed := strings.TrimSpace(l)
+		if !strings.HasPrefix(trimmed, "--") {
+			break
+		}
+		buf.WriteString(strings.TrimPrefix(trimmed, "--"))
+		buf.WriteByte('\n')
+	}
+
+	var ops []Operation
+	if err := json.Unmarshal([]byte(buf.String()), &ops); err != nil {
+		return nil, fmt.Errorf("operations: invalid operations block: %v", err)
+	}
+	return ops, nil
+}
+
+// syncFunctionName and syncTriggerName name the PL/pgSQL artifacts that keep
+// an old column and its shadow in sync during the expand phase. dir
+// distinguishes the two directions of sync ("fwd" for old->new, "rev" for
+// new->old), since each direction needs its own trigger.
+func syncFunctionName(op Operation, dir string) string {
+	return fmt.Sprintf("_expand_sync_%s_%s_%s", op.Table, op.Column, dir)
+}
+
+func syncTriggerName(op Operation, dir string) string {
+	return fmt.Sprintf("_expand_sync_%s_%s_%s_trg", op.Table, op.Column, dir)
+}
+
+// expandSyncTriggers returns the CREATE FUNCTION/CREATE TRIGGER statements
+// that keep srcColumn and dstColumn in sync in both directions while both
+// are live, so either the old application (writing srcColumn) or the new
+// one (writing dstColumn) observes the other's changes. Each direction's
+// trigger only recomputes its target when that direction's source actually
+// changed on UPDATE, or when the row is being inserted without a value for
+// the target — a plain unconditional "BEFORE
+// INSERT OR UPDATE" sync in one direction would otherwise have every write
+// to dstColumn alone immediately clobbered back to srcColumn's unchanged
+// value, and vice versa.
+func expandSyncTriggers(op Operation, srcColumn, dstColumn, srcToDst, dstToSrc string) []string {
+	table := pq.QuoteIdentifier(op.Table)
+	src := pq.QuoteIdentifier(srcColumn)
+	dst := pq.QuoteIdentifier(dstColumn)
+
+	direction := func(dir, changedColumn, targetColumn, assignExpr string) []string {
+		fn := syncFunctionName(op, dir)
+		trg := syncTriggerName(op, dir)
+
+		body := fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s() RETURNS trigger AS $$
+BEGIN
+	IF (TG_OP = 'INSERT' AND NEW.%s IS NULL) OR (TG_OP = 'UPDATE' AND NEW.%s IS DISTINCT FROM OLD.%s) THEN
+		NEW.%s := %s;
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql`, fn, targetColumn, changedColumn, changedColumn, targetColumn, assignExpr)
+
+		trigger := fmt.Sprintf("CREATE TRIGGER %s BEFORE INSERT OR UPDATE ON %s FOR EACH ROW EXECUTE PROCEDURE %s()", trg, table, fn)
+
+		return []string{body, trigger}
+	}
+
+	stmts := direction("fwd", src, dst, srcToDst)
+	return append(stmts, direction("rev", dst, src, dstToSrc)...)
+}
+
+// expandSQL returns the statements that implement the expand phase of op:
+// additive shadow columns kept in sync with the existing schema via
+// triggers, so both old and new code can run against the table at once.
+func expandSQL(op Operation) ([]string, error) {
+	table := pq.QuoteIdentifier(op.Table)
+
+	switch op.Type {
+	case "add_column":
+		def := ""
+		if op.Default != "" {
+			def = " DEFAULT " + op.Default
+		}
+		stmts := []string{
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s%s", table, pq.QuoteIdentifier(op.Column), op.NewType, def),
+		}
+		if op.NotNull {
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", table, pq.QuoteIdentifier(op.Column)))
+		}
+		return stmts, nil
+
+	case "drop_column":
+		// Expand does nothing: the column is left exactly as it is so old
+		// application instances keep reading and writing it under its
+		// original name. The contract phase is what actually drops it,
+		// once nothing is using it anymore.
+		return nil, nil
+
+	case "rename_column":
+		stmts := []string{
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, pq.QuoteIdentifier(op.NewColumn), op.NewType),
+		}
+		stmts = append(stmts, expandSyncTriggers(op, op.Column, op.NewColumn,
+			"NEW."+pq.QuoteIdentifier(op.Column),
+			"NEW."+pq.QuoteIdentifier(op.NewColumn))...)
+		stmts = append(stmts, fmt.Sprintf("UPDATE %s SET %s = %s", table, pq.QuoteIdentifier(op.NewColumn), pq.QuoteIdentifier(op.Column)))
+		return stmts, nil
+
+	case "change_type":
+		shadow := "_expand_" + op.Column
+		stmts := []string{
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, pq.QuoteIdentifier(shadow), op.NewType),
+		}
+		stmts = append(stmts, expandSyncTriggers(op, op.Column, shadow,
+			fmt.Sprintf("NEW.%s::%s", pq.QuoteIdentifier(op.Column), op.NewType),
+			"NEW."+pq.QuoteIdentifier(shadow))...)
+		stmts = append(stmts, fmt.Sprintf("UPDATE %s SET %s = %s::%s", table, pq.QuoteIdentifier(shadow), pq.QuoteIdentifier(op.Column), op.NewType))
+		return stmts, nil
+
+	default:
+		return nil, fmt.Errorf("operations: unknown operation type %q", op.Type)
+	}
+}
+
+// contractSQL returns the statements that implement the contract phase of
+// op: dropping the old artifacts once old application versions have been
+// fully rolled out.
+func contractSQL(op Operation) []string {
+	table := pq.QuoteIdentifier(op.Table)
+	dropSync := func() []string {
+		var stmts []string
+		for _, dir := range []string{"fwd", "rev"} {
+			stmts = append(stmts,
+				fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", syncTriggerName(op, dir), table),
+				fmt.Sprintf("DROP FUNCTION IF EXISTS %s()", syncFunctionName(op, dir)),
+			)
+		}
+		return stmts
+	}
+
+	switch op.Type {
+	case "add_column":
+		return nil
+
+	case "drop_column":
+		return []string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s", table, pq.QuoteIdentifier(op.Column))}
+
+	case "rename_column":
+		stmts := []string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s", table, pq.QuoteIdentifier(op.Column))}
+		return append(stmts, dropSync()...)
+
+	case "change_type":
+		shadow := "_expand_" + op.Column
+		stmts := []string{
+			fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s", table, pq.QuoteIdentifier(op.Column)),
+			fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, pq.QuoteIdentifier(shadow), pq.QuoteIdentifier(op.Column)),
+		}
+		return append(stmts, dropSync()...)
+
+	default:
+		return nil
+	}
+}
+
+// ensurePendingTableExists creates the bookkeeping table used to track
+// migrations whose expand phase has run but whose contract phase is still
+// outstanding.
+func (driver *Driver) ensurePendingTableExists() error {
+	_, err := driver.db.Exec("CREATE TABLE IF NOT EXISTS " + driver.qualifiedPendingTable() + " (version bigint not null primary key, operations jsonb not null)")
+	return err
+}
+
+// migrateExpand runs the expand phase for f's operations block inside tx and
+// records the migration as pending so Complete can contract it later.
+func (driver *Driver) migrateExpand(tx *sql.Tx, f file.File, ops []Operation) error {
+	for _, op := range ops {
+		stmts, err := expandSQL(op)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+	}
+
+	encoded, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO "+driver.qualifiedPendingTable()+" (version, operations) VALUES ($1, $2)", f.Version, encoded)
+	return err
+}
+
+// Complete runs the contract phase for a previously expanded migration,
+// dropping the old schema artifacts now that every application instance is
+// expected to be running the new code.
+func (driver *Driver) Complete(version file.Version) error {
+	var encoded []byte
+	err := driver.db.QueryRow("SELECT operations FROM "+driver.qualifiedPendingTable()+" WHERE version = $1", version).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("operations: no pending migration for version %v", version)
+	}
+	if err != nil {
+		return err
+	}
+
+	var ops []Operation
+	if err := json.Unmarshal(encoded, &ops); err != nil {
+		return err
+	}
+
+	tx, err := driver.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		for _, stmt := range contractSQL(op) {
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM "+driver.qualifiedPendingTable()+" WHERE version = $1", version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOperations(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []Operation
+		wantErr bool
+	}{
+		{
+			name:    "no operations header",
+			content: "-- a regular migration\nCREATE TABLE foo (id int);",
+			want:    nil,
+		},
+		{
+			name: "single operation",
+			content: `-- operations:
+-- [{"type": "add_column", "table": "users", "column": "email", "new_type": "text"}]
+`,
+			want: []Operation{
+				{Type: "add_column", Table: "users", Column: "email", NewType: "text"},
+			},
+		},
+		{
+			name: "multiple operations across lines",
+			content: `-- operations:
+-- [
+--   {"type": "add_column", "table": "users", "column": "email", "new_type": "text"},
+--   {"type": "drop_column", "table": "users", "column": "legacy_name"}
+-- ]
+CREATE TABLE placeholder (id int);
+`,
+			want: []Operation{
+				{Type: "add_column", Table: "users", Column: "email", NewType: "text"},
+				{Type: "drop_column", Table: "users", Column: "legacy_name"},
+			},
+		},
+		{
+			name: "invalid json",
+			content: `-- operations:
+-- not json
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOperations([]byte(tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseOperations(%q) = nil error, want error", tt.content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseOperations(%q) = %v, want no error", tt.content, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOperations(%q) = %+v, want %+v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandSQLDropColumnIsNoop(t *testing.T) {
+	stmts, err := expandSQL(Operation{Type: "drop_column", Table: "users", Column: "legacy_name"})
+	if err != nil {
+		t.Fatalf("expandSQL returned error: %v", err)
+	}
+	if len(stmts) != 0 {
+		t.Errorf("expandSQL(drop_column) = %v, want no statements so the column stays readable until Complete", stmts)
+	}
+}
+
+func TestExpandSQLUnknownOperation(t *testing.T) {
+	if _, err := expandSQL(Operation{Type: "bogus"}); err == nil {
+		t.Error("expandSQL(bogus) = nil error, want error for unrecognized operation type")
+	}
+}
+
+// TestExpandSQLRenameColumnSyncsBothDirections guards against a sync trigger
+// that only recomputes the new column from the old one: a write that only
+// touches the new column must not be clobbered by a stale read of the old
+// one, so the fwd and rev triggers must each guard on their own source
+// having changed (or the row being inserted).
+func TestExpandSQLRenameColumnSyncsBothDirections(t *testing.T) {
+	stmts, err := expandSQL(Operation{Type: "rename_column", Table: "users", Column: "name", NewColumn: "full_name", NewType: "text"})
+	if err != nil {
+		t.Fatalf("expandSQL returned error: %v", err)
+	}
+
+	var fwdGuard, revGuard bool
+	for _, stmt := range stmts {
+		if strings.Contains(stmt, "NEW.\"name\" IS DISTINCT FROM OLD.\"name\"") && strings.Contains(stmt, "NEW.\"full_name\" := ") {
+			fwdGuard = true
+		}
+		if strings.Contains(stmt, "NEW.\"full_name\" IS DISTINCT FROM OLD.\"full_name\"") && strings.Contains(stmt, "NEW.\"name\" := ") {
+			revGuard = true
+		}
+	}
+	if !fwdGuard {
+		t.Errorf("expandSQL(rename_column) has no old->new sync guarded on the old column changing: %v", stmts)
+	}
+	if !revGuard {
+		t.Errorf("expandSQL(rename_column) has no new->old sync guarded on the new column changing: %v", stmts)
+	}
+}
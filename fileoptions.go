@@ -0,0 +1,317 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Transaction modes understood by the transaction file option.
+const (
+	txNone         = "none"
+	txSingle       = "single"
+	txPerStatement = "per-statement"
+)
+
+// fileOptionHeaderPrefix marks a structured option line in a migration
+// file's leading comment block, e.g. "--! transaction=per-statement".
+const fileOptionHeaderPrefix = "--!"
+
+// FileOptions are the per-migration controls parsed from a file's leading
+// comment block by parseFileOptions.
+type FileOptions struct {
+	// Transaction is one of txNone, txSingle (default) or txPerStatement.
+	Transaction string
+	// StatementTimeout and LockTimeout, when non-zero, are applied with
+	// SET [LOCAL] statement_timeout / lock_timeout before the file's SQL
+	// runs.
+	StatementTimeout time.Duration
+	LockTimeout      time.Duration
+	// Role, when set, is applied with SET [LOCAL] ROLE before the file's
+	// SQL runs.
+	Role string
+	// IfExists, set by if_exists=skip, tolerates "already exists" / "does
+	// not exist" errors from an individual statement instead of aborting.
+	// It only has an effect when Transaction is txPerStatement, since that
+	// is the only mode in which a single statement can be skipped without
+	// discarding the whole migration.
+	IfExists bool
+}
+
+// parseFileOptions extracts per-migration options from content's leading
+// comment block. It understands two forms, for backwards compatibility:
+//
+//   - a legacy bare first line "-- disable_ddl_transaction", equivalent to
+//     "--! transaction=none" below;
+//   - a structured header of "--! key=value" lines, which may be
+//     interleaved with plain "--" comment lines and blank lines, ending at
+//     the first line that is neither blank nor a comment.
+//
+// Recognized keys are transaction, statement_timeout, lock_timeout, role
+// and if_exists.
+func parseFileOptions(content []byte) (FileOptions, error) {
+	opts := FileOptions{Transaction: txSingle}
+	lines := strings.Split(string(content), "\n")
+
+	if len(lines) > 0 {
+		first := strings.TrimSpace(lines[0])
+		if strings.HasPrefix(first, "-- ") {
+			for _, tok := range strings.Fields(strings.TrimPrefix(first, "-- ")) {
+				if tok == txDisabledOption {
+					opts.Transaction = txNone
+				}
+			}
+		}
+	}
+
+	for _, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if !strings.HasPrefix(trimmed, fileOptionHeaderPrefix) {
+			if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+				continue
+			}
+			break
+		}
+
+		kv := strings.TrimSpace(strings.TrimPrefix(trimmed, fileOptionHeaderPrefix))
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return opts, fmt.Errorf("fileoptions: malformed header line %q", l)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		switch key {
+		case "transaction":
+			switch value {
+			case txNone, txSingle, txPerStatement:
+				opts.Transaction = value
+			default:
+				return opts, fmt.Errorf("fileoptions: unknown transaction mode %q", value)
+			}
+		case "statement_timeout":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return opts, fmt.Errorf("fileoptions: statement_timeout: %v", err)
+			}
+			opts.StatementTimeout = d
+		case "lock_timeout":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return opts, fmt.Errorf("fileoptions: lock_timeout: %v", err)
+			}
+			opts.LockTimeout = d
+		case "role":
+			opts.Role = value
+		case "if_exists":
+			opts.IfExists = value == "skip"
+		default:
+			return opts, fmt.Errorf("fileoptions: unknown option %q", key)
+		}
+	}
+
+	return opts, nil
+}
+
+// sessionOptionStatements renders opts' timeout/role settings as SET
+// statements. local selects "SET LOCAL", scoping them to the current
+// transaction, over plain session-level "SET".
+func sessionOptionStatements(opts FileOptions, local bool) []string {
+	scope := "SET"
+	if local {
+		scope = "SET LOCAL"
+	}
+
+	var stmts []string
+	if opts.StatementTimeout > 0 {
+		stmts = append(stmts, fmt.Sprintf("%s statement_timeout = %d", scope, opts.StatementTimeout.Milliseconds()))
+	}
+	if opts.LockTimeout > 0 {
+		stmts = append(stmts, fmt.Sprintf("%s lock_timeout = %d", scope, opts.LockTimeout.Milliseconds()))
+	}
+	if opts.Role != "" {
+		stmts = append(stmts, fmt.Sprintf("%s ROLE %s", scope, pq.QuoteIdentifier(opts.Role)))
+	}
+	return stmts
+}
+
+// sessionResetStatements renders the RESET counterparts of
+// sessionOptionStatements' session-level SETs, so execWithoutTransaction can
+// undo them on the physical connection before it goes back to the pool.
+func sessionResetStatements(opts FileOptions) []string {
+	var stmts []string
+	if opts.StatementTimeout > 0 {
+		stmts = append(stmts, "RESET statement_timeout")
+	}
+	if opts.LockTimeout > 0 {
+		stmts = append(stmts, "RESET lock_timeout")
+	}
+	if opts.Role != "" {
+		stmts = append(stmts, "RESET ROLE")
+	}
+	return stmts
+}
+
+// execWithOptions runs content against tx according to opts.Transaction:
+// txNone runs outside of any transaction via execWithoutTransaction,
+// txPerStatement runs each statement in content as its own tx.Exec call,
+// and txSingle (the default) runs the whole file as one tx.Exec call.
+func (driver *Driver) execWithOptions(tx *sql.Tx, opts FileOptions, content []byte) error {
+	if opts.Transaction == txNone {
+		return driver.execWithoutTransaction(opts, content)
+	}
+
+	for _, stmt := range sessionOptionStatements(opts, true) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	if opts.Transaction != txPerStatement {
+		_, err := tx.Exec(string(content))
+		return err
+	}
+
+	for _, stmt := range splitStatements(string(content)) {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		// A failed statement aborts the whole transaction, so with
+		// if_exists=skip each statement runs under a savepoint that a
+		// skippable error rolls back to.
+		if opts.IfExists {
+			if _, err := tx.Exec("SAVEPOINT if_exists_skip"); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			if opts.IfExists && isIfExistsSkippable(err) {
+				if _, err := tx.Exec("ROLLBACK TO SAVEPOINT if_exists_skip"); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// execWithoutTransaction runs content outside of any transaction block, for
+// statements like CREATE INDEX CONCURRENTLY that Postgres rejects inside
+// one. It pins a single *sql.Conn for the SETs and content so they can't be
+// split across different pooled connections, and runs each SET as its own
+// statement rather than folding them into content's query string — a
+// multi-statement simple-query string is itself wrapped in an implicit
+// transaction block by Postgres, which would defeat transaction=none for
+// exactly the statements it exists to support. The SETs are session-level
+// (transaction=none has no transaction to scope a SET LOCAL to), so their
+// RESET counterparts run before the connection goes back to the pool,
+// keeping the setting from leaking onto whatever query reuses it next.
+func (driver *Driver) execWithoutTransaction(opts FileOptions, content []byte) error {
+	ctx := context.Background()
+	conn, err := driver.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, stmt := range sessionResetStatements(opts) {
+			conn.ExecContext(ctx, stmt)
+		}
+		conn.Close()
+	}()
+
+	for _, stmt := range sessionOptionStatements(opts, false) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.ExecContext(ctx, string(content))
+	return err
+}
+
+// isIfExistsSkippable reports whether err is the kind of "already exists" /
+// "does not exist" error that if_exists=skip tolerates.
+func isIfExistsSkippable(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return false
+	}
+	switch pqErr.Code {
+	case "42710", "42P07", "42703", "42P01", "42P06", "42704":
+		return true
+	default:
+		return false
+	}
+}
+
+// splitStatements splits sql on ';' boundaries, treating text inside
+// matching $tag$ ... $tag$ dollar-quoted bodies (as used by DO blocks and
+// function bodies) as opaque so embedded semicolons don't cause a false
+// split.
+func splitStatements(sql string) []string {
+	var stmts []string
+	var buf strings.Builder
+	var tag string
+
+	for i := 0; i < len(sql); {
+		if tag == "" {
+			if sql[i] == '$' {
+				if t, ok := matchDollarTag(sql[i:]); ok {
+					tag = t
+					buf.WriteString(t)
+					i += len(t)
+					continue
+				}
+			}
+			if sql[i] == ';' {
+				stmts = append(stmts, buf.String())
+				buf.Reset()
+				i++
+				continue
+			}
+			buf.WriteByte(sql[i])
+			i++
+			continue
+		}
+
+		if strings.HasPrefix(sql[i:], tag) {
+			buf.WriteString(tag)
+			i += len(tag)
+			tag = ""
+			continue
+		}
+		buf.WriteByte(sql[i])
+		i++
+	}
+
+	if strings.TrimSpace(buf.String()) != "" {
+		stmts = append(stmts, buf.String())
+	}
+	return stmts
+}
+
+// matchDollarTag matches a dollar-quote tag ("$$" or "$tag$") at the start
+// of s.
+func matchDollarTag(s string) (string, bool) {
+	if len(s) == 0 || s[0] != '$' {
+		return "", false
+	}
+	for j := 1; j < len(s); j++ {
+		if s[j] == '$' {
+			return s[:j+1], true
+		}
+		if !isTagRune(s[j]) {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func isTagRune(b byte) bool {
+	return b == '_' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
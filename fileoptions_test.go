@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFileOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    FileOptions
+		wantErr bool
+	}{
+		{
+			name:    "defaults",
+			content: "CREATE TABLE foo (id int);",
+			want:    FileOptions{Transaction: txSingle},
+		},
+		{
+			name:    "legacy disable_ddl_transaction",
+			content: "-- disable_ddl_transaction\nCREATE INDEX CONCURRENTLY ON foo (id);",
+			want:    FileOptions{Transaction: txNone},
+		},
+		{
+			name:    "structured header",
+			content: "--! transaction=per-statement\n--! statement_timeout=5s\n--! lock_timeout=1s\n--! role=app\n--! if_exists=skip\nCREATE TABLE foo (id int);",
+			want: FileOptions{
+				Transaction:      txPerStatement,
+				StatementTimeout: 5 * time.Second,
+				LockTimeout:      1 * time.Second,
+				Role:             "app",
+				IfExists:         true,
+			},
+		},
+		{
+			name:    "header interleaved with plain comments",
+			content: "-- a migration\n--! transaction=none\n-- more context\nCREATE TABLE foo (id int);",
+			want:    FileOptions{Transaction: txNone},
+		},
+		{
+			name:    "malformed header line",
+			content: "--! transaction\nCREATE TABLE foo (id int);",
+			wantErr: true,
+		},
+		{
+			name:    "unknown transaction mode",
+			content: "--! transaction=bogus\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown option",
+			content: "--! bogus=1\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFileOptions([]byte(tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseFileOptions(%q) = nil error, want error", tt.content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFileOptions(%q) = %v, want no error", tt.content, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFileOptions(%q) = %+v, want %+v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{
+			name: "simple statements",
+			sql:  "SELECT 1; SELECT 2;",
+			want: []string{"SELECT 1", " SELECT 2"},
+		},
+		{
+			name: "dollar-quoted body with embedded semicolons",
+			sql:  "DO $$ BEGIN INSERT INTO t VALUES (1); END; $$; SELECT 1;",
+			want: []string{"DO $$ BEGIN INSERT INTO t VALUES (1); END; $$", " SELECT 1"},
+		},
+		{
+			name: "tagged dollar-quote",
+			sql:  "CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;",
+			want: []string{"CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql"},
+		},
+		{
+			name: "trailing statement without semicolon",
+			sql:  "SELECT 1",
+			want: []string{"SELECT 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitStatements(tt.sql)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements(%q) = %#v, want %#v", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package postgres
+
+import "testing"
+
+func TestLockKeyStableAndDistinctPerTable(t *testing.T) {
+	a := &Driver{schema: "public", table: "schema_migrations"}
+	b := &Driver{schema: "public", table: "schema_migrations"}
+	if a.lockKey() != b.lockKey() {
+		t.Errorf("lockKey() differs for two drivers with the same qualified table")
+	}
+
+	c := &Driver{schema: "tenant_a", table: "schema_migrations"}
+	if a.lockKey() == c.lockKey() {
+		t.Errorf("lockKey() collided for different schemas: %d", a.lockKey())
+	}
+}
@@ -0,0 +1,134 @@
+package postgres
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// pgDumpSchema shells out to pg_dump to capture the schema (no data, no
+// ownership) of the database at connURL, so two schema states can be diffed
+// line by line once normalizeDump has removed comments and blank lines.
+// Object ordering is not normalized.
+func pgDumpSchema(connURL string) (string, error) {
+	u, err := url.Parse(connURL)
+	if err != nil {
+		return "", err
+	}
+	stripDriverParams(u)
+
+	cmd := exec.Command("pg_dump", "--schema-only", "--no-owner", u.String())
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("pg_dump: %v: %s", err, stderr.String())
+	}
+	return out.String(), nil
+}
+
+var dumpCommentRe = regexp.MustCompile(`(?m)^--.*$`)
+
+// normalizeDump strips a pg_dump schema dump of content that varies between
+// otherwise-equivalent dumps (comments, blank lines) so VerifySchema only
+// reports genuine drift.
+func normalizeDump(dump string) string {
+	dump = dumpCommentRe.ReplaceAllString(dump, "")
+	lines := strings.Split(dump, "\n")
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		out = append(out, strings.TrimRight(l, " \t"))
+	}
+	return strings.Join(out, "\n")
+}
+
+// diffDumps returns a line-level diff of two normalized schema dumps. ok is
+// true when the dumps are identical.
+func diffDumps(a, b string) (diff string, ok bool) {
+	if a == b {
+		return "", true
+	}
+
+	linesA := strings.Split(a, "\n")
+	linesB := strings.Split(b, "\n")
+
+	max := len(linesA)
+	if len(linesB) > max {
+		max = len(linesB)
+	}
+
+	var buf strings.Builder
+	for i := 0; i < max; i++ {
+		var la, lb string
+		if i < len(linesA) {
+			la = linesA[i]
+		}
+		if i < len(linesB) {
+			lb = linesB[i]
+		}
+		if la == lb {
+			continue
+		}
+		fmt.Fprintf(&buf, "- %s\n+ %s\n", la, lb)
+	}
+	return buf.String(), false
+}
+
+// randSuffix returns a short random hex string used to name temporary
+// databases so concurrent verification runs don't collide.
+func randSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// provisionTempDB creates a throwaway database alongside the one named in
+// urlTemplate and returns its connection URL plus a cleanup func that drops
+// it.
+func provisionTempDB(urlTemplate string) (tempURL string, cleanup func() error, err error) {
+	u, err := url.Parse(urlTemplate)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := strings.TrimPrefix(u.Path, "/") + "_verify_" + randSuffix()
+
+	maintURL := *u
+	maintURL.Path = "/postgres"
+	stripDriverParams(&maintURL)
+	maint, err := sql.Open("postgres", maintURL.String())
+	if err != nil {
+		return "", nil, err
+	}
+	defer maint.Close()
+
+	if _, err := maint.Exec(fmt.Sprintf("CREATE DATABASE %s", pq.QuoteIdentifier(name))); err != nil {
+		return "", nil, err
+	}
+
+	result := *u
+	result.Path = "/" + name
+
+	cleanup = func() error {
+		maint, err := sql.Open("postgres", maintURL.String())
+		if err != nil {
+			return err
+		}
+		defer maint.Close()
+		_, err = maint.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", pq.QuoteIdentifier(name)))
+		return err
+	}
+
+	return result.String(), cleanup, nil
+}
@@ -0,0 +1,37 @@
+package postgres
+
+import "testing"
+
+func TestNormalizeDump(t *testing.T) {
+	dump := "-- PostgreSQL database dump\n" +
+		"\n" +
+		"CREATE TABLE foo (   \n" +
+		"    id integer\n" +
+		");\n" +
+		"-- Completed\n"
+
+	got := normalizeDump(dump)
+	want := "CREATE TABLE foo (\n    id integer\n);"
+
+	if got != want {
+		t.Errorf("normalizeDump(%q) = %q, want %q", dump, got, want)
+	}
+}
+
+func TestDiffDumps(t *testing.T) {
+	a := normalizeDump("CREATE TABLE foo (id integer);\n")
+	b := normalizeDump("CREATE TABLE foo (id integer);\n")
+
+	if diff, ok := diffDumps(a, b); !ok {
+		t.Errorf("diffDumps(identical dumps) = (%q, false), want ok=true", diff)
+	}
+
+	c := normalizeDump("CREATE TABLE foo (id bigint);\n")
+	diff, ok := diffDumps(a, c)
+	if ok {
+		t.Fatal("diffDumps(differing dumps) = ok=true, want ok=false")
+	}
+	if diff == "" {
+		t.Error("diffDumps(differing dumps) returned an empty diff")
+	}
+}
@@ -4,8 +4,11 @@ package postgres
 import (
 	"database/sql"
 	"fmt"
+	"io/fs"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/db-journey/migrate/direction"
 	"github.com/db-journey/migrate/driver"
@@ -18,17 +21,54 @@ var _ driver.Driver = (*Driver)(nil)
 // Driver is the postgres driver for journey.
 type Driver struct {
 	db *sql.DB
+
+	// schema and table locate the migrations version table. They default
+	// to "public" and "schema_migrations" but can be overridden per
+	// connection via the x-migrations-schema and x-migrations-table URL
+	// query parameters, or by a search_path query parameter when
+	// x-migrations-schema is absent.
+	schema string
+	table  string
+
+	// LockTimeout bounds how long Lock waits to acquire the advisory
+	// lock that guards the migrations table before giving up with
+	// ErrLocked. Zero, the default, blocks until the lock is available.
+	LockTimeout time.Duration
+
+	// source, set via SetSource, is consulted by Migrate instead of the
+	// local filesystem when reading a migration file's content.
+	source fs.FS
+
+	// lockConn is the single physical connection holding the session-level
+	// advisory lock taken by Lock, held pinned for the locked region so
+	// Unlock releases it on the same connection that acquired it.
+	lockConn *sql.Conn
 }
 
-const tableName = "public.schema_migrations"
+const defaultMigrationsSchema = "public"
+const defaultMigrationsTable = "schema_migrations"
 const txDisabledOption = "disable_ddl_transaction"
 
 // make sure our driver still implements the driver.Driver interface
 var _ driver.Driver = (*Driver)(nil)
 
 // Initialize opens and verifies the database handle.
-func (driver *Driver) Initialize(url string) error {
-	db, err := sql.Open("postgres", url)
+func (driver *Driver) Initialize(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+
+	schema, table, err := parseMigrationsTableConfig(u)
+	if err != nil {
+		return err
+	}
+	driver.schema = schema
+	driver.table = table
+
+	stripDriverParams(u)
+
+	db, err := sql.Open("postgres", u.String())
 	if err != nil {
 		return err
 	}
@@ -37,7 +77,70 @@ func (driver *Driver) Initialize(url string) error {
 	}
 	driver.db = db
 
-	return driver.ensureVersionTableExists()
+	if _, err := db.Exec("CREATE SCHEMA IF NOT EXISTS " + pq.QuoteIdentifier(driver.schema)); err != nil {
+		return err
+	}
+	if _, err := db.Exec("SET search_path TO " + pq.QuoteIdentifier(driver.schema)); err != nil {
+		return err
+	}
+
+	if err := driver.ensureVersionTableExists(); err != nil {
+		return err
+	}
+	return driver.ensurePendingTableExists()
+}
+
+// parseMigrationsTableConfig resolves the schema and table name that hold
+// the migrations version table from u's query parameters:
+// x-migrations-schema and x-migrations-table take precedence, falling back
+// to the first entry of search_path for the schema, and to
+// "public"/"schema_migrations" otherwise.
+func parseMigrationsTableConfig(u *url.URL) (schema, table string, err error) {
+	q := u.Query()
+
+	schema = q.Get("x-migrations-schema")
+	if schema == "" {
+		if sp := q.Get("search_path"); sp != "" {
+			schema = strings.TrimSpace(strings.SplitN(sp, ",", 2)[0])
+		}
+	}
+	if schema == "" {
+		schema = defaultMigrationsSchema
+	}
+
+	table = q.Get("x-migrations-table")
+	if table == "" {
+		table = defaultMigrationsTable
+	}
+
+	return schema, table, nil
+}
+
+// qualifiedTable returns the migrations version table as a quoted
+// "schema"."table" identifier suitable for inlining into SQL.
+func (driver *Driver) qualifiedTable() string {
+	schema, table := driver.schema, driver.table
+	if schema == "" {
+		schema = defaultMigrationsSchema
+	}
+	if table == "" {
+		table = defaultMigrationsTable
+	}
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(table)
+}
+
+// stripDriverParams removes driver-specific query parameters (those
+// prefixed with "x-", e.g. x-migrations-schema or x-template) from u.
+// lib/pq forwards any query parameter it doesn't recognize to Postgres as a
+// runtime GUC, so these driver-only switches must never reach sql.Open.
+func stripDriverParams(u *url.URL) {
+	q := u.Query()
+	for k := range q {
+		if strings.HasPrefix(k, "x-") {
+			q.Del(k)
+		}
+	}
+	u.RawQuery = q.Encode()
 }
 
 // SetDB replaces the current database handle.
@@ -51,21 +154,29 @@ func (driver *Driver) Close() error {
 }
 
 func (driver *Driver) ensureVersionTableExists() error {
+	schema, table := driver.schema, driver.table
+	if schema == "" {
+		schema = defaultMigrationsSchema
+	}
+	if table == "" {
+		table = defaultMigrationsTable
+	}
+
 	// avoid DDL statements if possible for BDR (see #23)
 	var c int
-	if err := driver.db.QueryRow("SELECT count(*) FROM information_schema.tables WHERE table_name = $1", tableName).Scan(&c); err != nil {
+	if err := driver.db.QueryRow("SELECT count(*) FROM information_schema.tables WHERE table_schema = $1 AND table_name = $2", schema, table).Scan(&c); err != nil {
 		return err
 	}
 
 	if c <= 0 {
-		_, err := driver.db.Exec("CREATE TABLE IF NOT EXISTS " + tableName + " (version bigint not null primary key)")
+		_, err := driver.db.Exec("CREATE TABLE IF NOT EXISTS " + driver.qualifiedTable() + " (version bigint not null primary key)")
 		return err
 	}
 
 	// table schema_migrations already exists, check if the schema is correct, ie: version is a bigint
 
 	var dataType string
-	if err := driver.db.QueryRow("SELECT data_type FROM information_schema.columns where table_name = $1 and column_name = 'version'", tableName).Scan(&dataType); err != nil {
+	if err := driver.db.QueryRow("SELECT data_type FROM information_schema.columns where table_schema = $1 and table_name = $2 and column_name = 'version'", schema, table).Scan(&dataType); err != nil {
 		return err
 	}
 
@@ -73,7 +184,7 @@ func (driver *Driver) ensureVersionTableExists() error {
 		return nil
 	}
 
-	_, err := driver.db.Exec("ALTER TABLE " + tableName + " ALTER COLUMN version TYPE bigint USING version::bigint")
+	_, err := driver.db.Exec("ALTER TABLE " + driver.qualifiedTable() + " ALTER COLUMN version TYPE bigint USING version::bigint")
 	return err
 }
 
@@ -82,7 +193,31 @@ func (driver *Driver) FilenameExtension() string {
 	return "sql"
 }
 
-// Migrate performs the migration of any one file.
+// migrateContent runs f.Content against tx, dispatching to the
+// expand/contract path when f carries an operations block and to the plain
+// file-options path otherwise. Migrate and migrateAllAtOnce both funnel
+// through here so a migration runs identically regardless of how it's
+// applied.
+func (driver *Driver) migrateContent(tx *sql.Tx, f file.File) error {
+	ops, err := parseOperations(f.Content)
+	if err != nil {
+		return err
+	}
+
+	if ops != nil {
+		return driver.migrateExpand(tx, f, ops)
+	}
+
+	fopts, err := parseFileOptions(f.Content)
+	if err != nil {
+		return err
+	}
+	return driver.execWithOptions(tx, fopts, f.Content)
+}
+
+// Migrate performs the migration of any one file. It does not itself take
+// the advisory lock: callers running a batch of files should wrap the whole
+// batch in Lock/Unlock, once, rather than per file — see Lock.
 func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 	defer close(pipe)
 	pipe <- f
@@ -94,7 +229,7 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 	}
 
 	if f.Direction == direction.Up {
-		if _, err = tx.Exec("INSERT INTO "+tableName+" (version) VALUES ($1)", f.Version); err != nil {
+		if _, err = tx.Exec("INSERT INTO "+driver.qualifiedTable()+" (version) VALUES ($1)", f.Version); err != nil {
 			pipe <- err
 			if err = tx.Rollback(); err != nil {
 				pipe <- err
@@ -102,7 +237,7 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 			return
 		}
 	} else if f.Direction == direction.Down {
-		if _, err = tx.Exec("DELETE FROM "+tableName+" WHERE version=$1", f.Version); err != nil {
+		if _, err = tx.Exec("DELETE FROM "+driver.qualifiedTable()+" WHERE version=$1", f.Version); err != nil {
 			pipe <- err
 			if err = tx.Rollback(); err != nil {
 				pipe <- err
@@ -111,26 +246,29 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 		}
 	}
 
-	if err = f.ReadContent(); err != nil {
+	if err = driver.readContent(&f); err != nil {
 		pipe <- err
 		return
 	}
 
-	if txDisabled(fileOptions(f.Content)) {
-		_, err = driver.db.Exec(string(f.Content))
-	} else {
-		_, err = tx.Exec(string(f.Content))
-	}
+	err = driver.migrateContent(tx, f)
 
 	if err != nil {
-		pqErr := err.(*pq.Error)
-		offset, err := strconv.Atoi(pqErr.Position)
-		if err == nil && offset >= 0 {
-			lineNo, columnNo := file.LineColumnFromOffset(f.Content, offset-1)
-			errorPart := file.LinesBeforeAndAfter(f.Content, lineNo, 5, 5, true)
-			pipe <- fmt.Errorf("%s %v: %s in line %v, column %v:\n\n%s", pqErr.Severity, pqErr.Code, pqErr.Message, lineNo, columnNo, string(errorPart))
+		if pqErr, ok := err.(*pq.Error); ok {
+			offset, atoiErr := strconv.Atoi(pqErr.Position)
+			if atoiErr == nil && offset >= 0 {
+				lineNo, columnNo := file.LineColumnFromOffset(f.Content, offset-1)
+				errorPart := file.LinesBeforeAndAfter(f.Content, lineNo, 5, 5, true)
+				pipe <- fmt.Errorf("%s %v: %s in line %v, column %v:\n\n%s", pqErr.Severity, pqErr.Code, pqErr.Message, lineNo, columnNo, string(errorPart))
+			} else {
+				pipe <- fmt.Errorf("%s %v: %s", pqErr.Severity, pqErr.Code, pqErr.Message)
+			}
 		} else {
-			pipe <- fmt.Errorf("%s %v: %s", pqErr.Severity, pqErr.Code, pqErr.Message)
+			// migrateExpand can fail with a plain error (e.g. an
+			// unrecognized operation type or a json.Marshal failure)
+			// that isn't a *pq.Error; forward it as-is instead of
+			// panicking on the type assertion.
+			pipe <- err
 		}
 
 		if err := tx.Rollback(); err != nil {
@@ -148,7 +286,7 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 // Version returns the current migration version.
 func (driver *Driver) Version() (file.Version, error) {
 	var version file.Version
-	err := driver.db.QueryRow("SELECT version FROM " + tableName + " ORDER BY version DESC LIMIT 1").Scan(&version)
+	err := driver.db.QueryRow("SELECT version FROM " + driver.qualifiedTable() + " ORDER BY version DESC LIMIT 1").Scan(&version)
 	if err == sql.ErrNoRows {
 		return version, nil
 	}
@@ -158,7 +296,7 @@ func (driver *Driver) Version() (file.Version, error) {
 
 // Versions returns the list of applied migrations.
 func (driver *Driver) Versions() (file.Versions, error) {
-	rows, err := driver.db.Query("SELECT version FROM " + tableName + " ORDER BY version DESC")
+	rows, err := driver.db.Query("SELECT version FROM " + driver.qualifiedTable() + " ORDER BY version DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -186,26 +324,6 @@ func (driver *Driver) Execute(statement string) error {
 	return err
 }
 
-// fileOptions returns the list of options extracted from the first line of the file content.
-// Format: "-- <option1> <option2> <...>"
-func fileOptions(content []byte) []string {
-	firstLine := strings.SplitN(string(content), "\n", 2)[0]
-	if !strings.HasPrefix(firstLine, "-- ") {
-		return []string{}
-	}
-	opts := strings.TrimPrefix(firstLine, "-- ")
-	return strings.Split(opts, " ")
-}
-
-func txDisabled(opts []string) bool {
-	for _, v := range opts {
-		if v == txDisabledOption {
-			return true
-		}
-	}
-	return false
-}
-
 func init() {
 	// According to the PostgreSQL documentation (section 32.1.1.2), postgres
 	// library supports two URI schemes: postgresql:// and postgres://
@@ -0,0 +1,73 @@
+package postgres
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseMigrationsTableConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		rawurl     string
+		wantSchema string
+		wantTable  string
+	}{
+		{
+			name:       "defaults",
+			rawurl:     "postgres://localhost/db",
+			wantSchema: defaultMigrationsSchema,
+			wantTable:  defaultMigrationsTable,
+		},
+		{
+			name:       "x-migrations-schema and x-migrations-table",
+			rawurl:     "postgres://localhost/db?x-migrations-schema=tenant_a&x-migrations-table=versions",
+			wantSchema: "tenant_a",
+			wantTable:  "versions",
+		},
+		{
+			name:       "search_path fallback for schema",
+			rawurl:     "postgres://localhost/db?search_path=tenant_b,public",
+			wantSchema: "tenant_b",
+			wantTable:  defaultMigrationsTable,
+		},
+		{
+			name:       "x-migrations-schema takes precedence over search_path",
+			rawurl:     "postgres://localhost/db?search_path=tenant_b,public&x-migrations-schema=tenant_a",
+			wantSchema: "tenant_a",
+			wantTable:  defaultMigrationsTable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.rawurl)
+			if err != nil {
+				t.Fatalf("url.Parse(%q) = %v", tt.rawurl, err)
+			}
+			schema, table, err := parseMigrationsTableConfig(u)
+			if err != nil {
+				t.Fatalf("parseMigrationsTableConfig(%q) = %v, want no error", tt.rawurl, err)
+			}
+			if schema != tt.wantSchema || table != tt.wantTable {
+				t.Errorf("parseMigrationsTableConfig(%q) = (%q, %q), want (%q, %q)", tt.rawurl, schema, table, tt.wantSchema, tt.wantTable)
+			}
+		})
+	}
+}
+
+func TestStripDriverParams(t *testing.T) {
+	u, err := url.Parse("postgres://localhost/db?x-migrations-schema=tenant_a&x-template=template0&sslmode=disable")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	stripDriverParams(u)
+
+	q := u.Query()
+	if q.Get("x-migrations-schema") != "" || q.Get("x-template") != "" {
+		t.Errorf("stripDriverParams left driver params in %q", u.String())
+	}
+	if q.Get("sslmode") != "disable" {
+		t.Errorf("stripDriverParams removed a non-driver param from %q", u.String())
+	}
+}
@@ -0,0 +1,34 @@
+package postgres
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/db-journey/migrate/file"
+)
+
+// SetSource configures fsys as the filesystem migrations are read from,
+// instead of the local filesystem that file.File.ReadContent reads from by
+// default. This lets applications embed their migrations in the binary via
+// embed.FS and still use Driver.Migrate directly.
+func (driver *Driver) SetSource(fsys fs.FS) {
+	driver.source = fsys
+}
+
+// readContent populates f.Content from driver.source when one has been
+// configured via SetSource, falling back to f.ReadContent's local
+// filesystem read otherwise.
+func (driver *Driver) readContent(f *file.File) error {
+	if driver.source == nil {
+		return f.ReadContent()
+	}
+
+	name := strings.TrimPrefix(path.Join(f.Path, f.FileName), "/")
+	content, err := fs.ReadFile(driver.source, name)
+	if err != nil {
+		return err
+	}
+	f.Content = content
+	return nil
+}
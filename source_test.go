@@ -0,0 +1,33 @@
+package postgres
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/db-journey/migrate/file"
+)
+
+func TestReadContentFromSource(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0001_init.up.sql": &fstest.MapFile{Data: []byte("CREATE TABLE foo (id int);")},
+	}
+
+	driver := &Driver{source: fsys}
+	f := &file.File{Path: "migrations", FileName: "0001_init.up.sql"}
+
+	if err := driver.readContent(f); err != nil {
+		t.Fatalf("readContent() = %v, want no error", err)
+	}
+	if string(f.Content) != "CREATE TABLE foo (id int);" {
+		t.Errorf("readContent() content = %q, want %q", f.Content, "CREATE TABLE foo (id int);")
+	}
+}
+
+func TestReadContentFromSourceMissingFile(t *testing.T) {
+	driver := &Driver{source: fstest.MapFS{}}
+	f := &file.File{Path: "migrations", FileName: "missing.up.sql"}
+
+	if err := driver.readContent(f); err == nil {
+		t.Error("readContent() = nil error, want error for missing file")
+	}
+}
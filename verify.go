@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/db-journey/migrate/direction"
+	"github.com/db-journey/migrate/file"
+)
+
+// VerifySchema checks that the migrations with a version in (from, to]
+// produce the same resulting schema regardless of how they are applied:
+// once as a single all-at-once transaction, and once one file at a time as
+// Migrate normally runs them. This catches migrations whose result depends
+// on application order or granularity, which running "migrate" against a
+// single database cannot detect.
+//
+// files must be sorted ascending by version. urlTemplate is a connection
+// URL used to provision two disposable databases, one per application
+// order, which are dropped before VerifySchema returns.
+func (driver *Driver) VerifySchema(from, to file.Version, files []file.File, urlTemplate string) error {
+	batch := selectRange(files, from, to)
+	if len(batch) == 0 {
+		return fmt.Errorf("verify: no migrations between %v and %v", from, to)
+	}
+
+	allAtOnce, err := dumpAfterApplying(urlTemplate, batch, true)
+	if err != nil {
+		return fmt.Errorf("verify: all-at-once: %v", err)
+	}
+
+	stepped, err := dumpAfterApplying(urlTemplate, batch, false)
+	if err != nil {
+		return fmt.Errorf("verify: one-at-a-time: %v", err)
+	}
+
+	if diff, ok := diffDumps(normalizeDump(allAtOnce), normalizeDump(stepped)); !ok {
+		return fmt.Errorf("verify: schema drift between all-at-once and one-at-a-time application:\n%s", diff)
+	}
+	return nil
+}
+
+// selectRange returns the ascending subset of files with from < version <= to.
+func selectRange(files []file.File, from, to file.Version) []file.File {
+	var out []file.File
+	for _, f := range files {
+		if f.Version > from && f.Version <= to {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// dumpAfterApplying provisions a temporary database, applies batch to it in
+// the requested order, and returns the resulting pg_dump schema-only
+// output.
+func dumpAfterApplying(urlTemplate string, batch []file.File, allAtOnce bool) (string, error) {
+	tempURL, cleanup, err := provisionTempDB(urlTemplate)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	tempDriver := &Driver{}
+	if err := tempDriver.Initialize(tempURL); err != nil {
+		return "", err
+	}
+	defer tempDriver.Close()
+
+	if err := tempDriver.Lock(); err != nil {
+		return "", err
+	}
+	defer tempDriver.Unlock()
+
+	if allAtOnce {
+		if err := tempDriver.migrateAllAtOnce(batch); err != nil {
+			return "", err
+		}
+	} else {
+		for _, f := range batch {
+			f.Direction = direction.Up
+			pipe := make(chan interface{})
+			go tempDriver.Migrate(f, pipe)
+			for v := range pipe {
+				if migrateErr, ok := v.(error); ok {
+					return "", migrateErr
+				}
+			}
+		}
+	}
+
+	return pgDumpSchema(tempURL)
+}
+
+// migrateAllAtOnce applies every file in batch inside a single transaction,
+// mirroring a deploy pipeline that runs a whole migration range in one step
+// instead of one file at a time. It dispatches each file through the same
+// migrateContent logic Migrate uses, so an operations-block migration runs
+// its expand phase here too instead of being applied as inert raw SQL.
+func (driver *Driver) migrateAllAtOnce(batch []file.File) error {
+	tx, err := driver.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range batch {
+		f.Direction = direction.Up
+		if err := f.ReadContent(); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := driver.migrateContent(tx, f); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO "+driver.qualifiedTable()+" (version) VALUES ($1)", f.Version); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}